@@ -3,121 +3,406 @@
 package kbucket
 
 import (
-	"container/list"
+	"math/rand"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/libp2p/go-libp2p-core/peer"
 )
 
-// Bucket holds a list of peers.
+// smallBucketLimit is how many entries a Bucket keeps in a plain sorted
+// slice before switching to a treap. Benchmarking showed the treap only
+// pays for itself past roughly size=64 (~35% slower than a linear
+// insertion-sorted slice at the k=20 default, ~2.6x faster by size=256);
+// below this limit a Bucket stays on the slice so the common case - every
+// bucket in a table, which normally never exceeds k - doesn't regress.
+// See bucket_list_old_test.go (-tags=oldbucketbench) for the benchmark
+// that compares the two.
+// Once a Bucket crosses the limit it stays on the treap even if entries
+// are later removed, since churn around the boundary isn't worth tracking.
+const smallBucketLimit = 64
+
+// Bucket holds a set of peers, ordered front-to-back by its comparator
+// (latency ascending by default) with the most recently MoveToFront-ed
+// peers pinned ahead of that ordering.
+//
+// Internally a Bucket is a map[peer.ID]*bucketEntry for O(1) membership
+// tests, plus one of two orderings: a plain slice kept sorted by the
+// comparator via insertion sort (cheap for the small sizes every bucket
+// normally stays at) up to smallBucketLimit entries, or a treap keyed by
+// the comparator once a bucket grows past that - trading the slice's O(n)
+// insert for the treap's O(log n) insert and pop-worst once n is large
+// enough for that to matter. Split still visits every entry either way.
 type Bucket struct {
-	lk   sync.RWMutex
-	list *list.List
+	lk       sync.RWMutex
+	entries  map[peer.ID]*bucketEntry
+	order    []*bucketEntry
+	root     *treapNode
+	useTreap bool
+	rnd      *rand.Rand
+	seq      uint64
+	cmp      PeerLess
+
+	replacements   []*bucketEntry
+	replacementCap int
+	promotions     uint64
+	failedProbes   uint64
 }
 
+// PeerIDLatency is retained for API compatibility with callers that
+// constructed it directly; Bucket no longer stores peers in this form
+// internally.
 type PeerIDLatency struct {
 	ID      peer.ID
 	Latency time.Duration
 }
 
+// bucketEntry is the internal record kept for each peer in a Bucket.
+type bucketEntry struct {
+	id      peer.ID
+	score   PeerScore
+	seq     uint64
+	pinned  bool
+	pinSeq  uint64
+	addedAt time.Time
+}
+
+// NewBucket creates an empty Bucket, applying any BucketOptions given. It
+// is exported so packages that build their own bucket layout on top of
+// this one (e.g. kbucket/dispatch) can construct buckets without a
+// RoutingTable.
+func NewBucket(opts ...BucketOption) *Bucket {
+	b := newBucket()
+	o := newBucketOptions(opts...)
+	b.cmp = o.cmp
+	b.replacementCap = o.replacementCacheSize
+	return b
+}
+
 func newBucket() *Bucket {
 	b := new(Bucket)
-	b.list = list.New()
+	b.entries = make(map[peer.ID]*bucketEntry)
+	b.rnd = rand.New(rand.NewSource(time.Now().UnixNano()))
 	return b
 }
 
+// less is the ordering used by this bucket's treap: pinned entries first
+// (most recently pinned foremost), then by b.cmp if one is set, falling
+// back to plain ascending latency, with insertion order as the final
+// tie-break so the ordering is always a strict weak ordering.
+func (b *Bucket) less(x, y *bucketEntry) bool {
+	if x.pinned != y.pinned {
+		return x.pinned
+	}
+	if x.pinned {
+		return x.pinSeq > y.pinSeq
+	}
+	if b.cmp != nil {
+		if b.cmp(x.score, y.score) {
+			return true
+		}
+		if b.cmp(y.score, x.score) {
+			return false
+		}
+	} else if x.score.Latency != y.score.Latency {
+		return x.score.Latency < y.score.Latency
+	}
+	return x.seq < y.seq
+}
+
+// SetComparator overrides the PeerLess used to order this bucket's peers.
+// Passing nil restores the default ascending-latency ordering.
+func (b *Bucket) SetComparator(cmp PeerLess) {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+	b.setComparatorLocked(cmp)
+}
+
+// setComparatorLocked changes b.cmp on a bucket that may already hold
+// entries: it re-orders them so every existing entry's position reflects
+// the new comparator, not just entries inserted afterwards. (NewBucket and
+// Split set b.cmp directly, on a bucket that is still empty.) Callers must
+// hold b.lk.
+func (b *Bucket) setComparatorLocked(cmp PeerLess) {
+	b.cmp = cmp
+	b.rebuildLocked()
+}
+
+// rebuildLocked re-orders every entry under the current comparator,
+// without changing which of the slice or treap ordering is in use.
+// Callers must hold b.lk.
+func (b *Bucket) rebuildLocked() {
+	if !b.useTreap {
+		sort.SliceStable(b.order, func(i, j int) bool { return b.less(b.order[i], b.order[j]) })
+		return
+	}
+	old := b.root
+	b.root = nil
+	treapInOrder(old, func(e *bucketEntry) bool {
+		b.root = treapInsert(b.root, &treapNode{entry: e, priority: b.rnd.Int63()}, b.less)
+		return true
+	})
+}
+
+// insertLocked adds e to the entry map and its ordering, first removing
+// any existing entry for e.id so the ordering and map never diverge:
+// without this, pushing the same id twice (e.g. re-pushing a peer to
+// refresh its latency) would leave the old bucketEntry orphaned in the
+// ordering while the map only tracked the new one. Callers must hold b.lk.
+func (b *Bucket) insertLocked(e *bucketEntry) {
+	if old, ok := b.entries[e.id]; ok {
+		b.removeFromOrderingLocked(old)
+	}
+	b.entries[e.id] = e
+	if !b.useTreap {
+		b.insertIntoOrderLocked(e)
+		if len(b.order) > smallBucketLimit {
+			b.promoteToTreapLocked()
+		}
+	} else {
+		b.root = treapInsert(b.root, &treapNode{entry: e, priority: b.rnd.Int63()}, b.less)
+	}
+}
+
+// insertIntoOrderLocked inserts e into b.order at the position b.less
+// says it belongs, keeping the slice sorted. Callers must hold b.lk and
+// know b.useTreap is false.
+func (b *Bucket) insertIntoOrderLocked(e *bucketEntry) {
+	i := sort.Search(len(b.order), func(i int) bool { return b.less(e, b.order[i]) })
+	b.order = append(b.order, nil)
+	copy(b.order[i+1:], b.order[i:])
+	b.order[i] = e
+}
+
+// promoteToTreapLocked switches a Bucket from its small-size sorted slice
+// to a treap, once the slice has grown past smallBucketLimit. Callers
+// must hold b.lk.
+func (b *Bucket) promoteToTreapLocked() {
+	for _, e := range b.order {
+		b.root = treapInsert(b.root, &treapNode{entry: e, priority: b.rnd.Int63()}, b.less)
+	}
+	b.order = nil
+	b.useTreap = true
+}
+
+// removeFromOrderingLocked removes e from whichever ordering is active.
+// Callers must hold b.lk.
+func (b *Bucket) removeFromOrderingLocked(e *bucketEntry) {
+	if b.useTreap {
+		b.root = treapDelete(b.root, e, b.less)
+		return
+	}
+	for i, cur := range b.order {
+		if cur == e {
+			b.order = append(b.order[:i], b.order[i+1:]...)
+			return
+		}
+	}
+}
+
+// removeLocked removes the entry for id, if present, returning it.
+// Callers must hold b.lk.
+func (b *Bucket) removeLocked(id peer.ID) *bucketEntry {
+	e, ok := b.entries[id]
+	if !ok {
+		return nil
+	}
+	b.removeFromOrderingLocked(e)
+	delete(b.entries, id)
+	return e
+}
+
+// frontLocked returns the entry ranked first, or nil if the bucket is
+// empty. Callers must hold b.lk (read lock suffices).
+func (b *Bucket) frontLocked() *bucketEntry {
+	if !b.useTreap {
+		if len(b.order) == 0 {
+			return nil
+		}
+		return b.order[0]
+	}
+	n := treapFront(b.root)
+	if n == nil {
+		return nil
+	}
+	return n.entry
+}
+
+// backLocked returns the entry ranked last, or nil if the bucket is
+// empty. Callers must hold b.lk (read lock suffices).
+func (b *Bucket) backLocked() *bucketEntry {
+	if !b.useTreap {
+		if len(b.order) == 0 {
+			return nil
+		}
+		return b.order[len(b.order)-1]
+	}
+	n := treapBack(b.root)
+	if n == nil {
+		return nil
+	}
+	return n.entry
+}
+
+// inOrderLocked calls f for every entry front to back, until f returns
+// false or every entry has been visited. Callers must hold b.lk (read
+// lock suffices).
+func (b *Bucket) inOrderLocked(f func(*bucketEntry) bool) {
+	if !b.useTreap {
+		for _, e := range b.order {
+			if !f(e) {
+				return
+			}
+		}
+		return
+	}
+	treapInOrder(b.root, f)
+}
+
+// reverseOrderLocked calls f for every entry back to front, until f
+// returns false or every entry has been visited. Callers must hold b.lk
+// (read lock suffices).
+func (b *Bucket) reverseOrderLocked(f func(*bucketEntry) bool) {
+	if !b.useTreap {
+		for i := len(b.order) - 1; i >= 0; i-- {
+			if !f(b.order[i]) {
+				return
+			}
+		}
+		return
+	}
+	treapReverseOrder(b.root, f)
+}
+
 func (b *Bucket) Peers() []peer.ID {
 	b.lk.RLock()
 	defer b.lk.RUnlock()
-	ps := make([]peer.ID, 0, b.list.Len())
-	for e := b.list.Front(); e != nil; e = e.Next() {
-		id := e.Value.(PeerIDLatency).ID
-		ps = append(ps, id)
-	}
+	ps := make([]peer.ID, 0, len(b.entries))
+	b.inOrderLocked(func(e *bucketEntry) bool {
+		ps = append(ps, e.id)
+		return true
+	})
 	return ps
 }
 
 func (b *Bucket) Has(id peer.ID) bool {
 	b.lk.RLock()
 	defer b.lk.RUnlock()
-	for e := b.list.Front(); e != nil; e = e.Next() {
-		if e.Value.(PeerIDLatency).ID == id {
-			return true
-		}
-	}
-	return false
+	_, ok := b.entries[id]
+	return ok
 }
 
 func (b *Bucket) Remove(id peer.ID) bool {
 	b.lk.Lock()
 	defer b.lk.Unlock()
-	for e := b.list.Front(); e != nil; e = e.Next() {
-		if e.Value.(PeerIDLatency).ID == id {
-			b.list.Remove(e)
-			return true
-		}
-	}
-	return false
+	return b.removeLocked(id) != nil
 }
 
 func (b *Bucket) MoveToFront(id peer.ID) {
 	b.lk.Lock()
 	defer b.lk.Unlock()
-	for e := b.list.Front(); e != nil; e = e.Next() {
-		if e.Value.(PeerIDLatency).ID == id {
-			b.list.MoveToFront(e)
-		}
+	e := b.removeLocked(id)
+	if e == nil {
+		return
 	}
+	b.seq++
+	e.pinned = true
+	e.pinSeq = b.seq
+	b.insertLocked(e)
 }
 
 func (b *Bucket) PushFront(p peer.ID) {
 	b.lk.Lock()
-	b.list.PushFront(PeerIDLatency{p, 0})
-	b.lk.Unlock()
+	defer b.lk.Unlock()
+	b.insertLocked(b.newEntryLocked(p, PeerScore{}))
 }
 
 func (b *Bucket) PushFrontWithLatency(p peer.ID, latency time.Duration) {
 	b.lk.Lock()
 	defer b.lk.Unlock()
+	b.insertLocked(b.newEntryLocked(p, PeerScore{Latency: latency}))
+}
 
-	e := b.list.Front()
-	for ; e != nil; e = e.Next() {
-		if e.Value.(PeerIDLatency).Latency > latency {
-			break
-		}
+// PushFrontScored inserts p ranked by the full PeerScore tuple, using
+// this bucket's comparator (MultilessComparator if none was set via
+// SetComparator) instead of plain latency.
+func (b *Bucket) PushFrontScored(p peer.ID, score PeerScore) {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+	if b.cmp == nil {
+		b.setComparatorLocked(MultilessComparator())
 	}
-	elem := PeerIDLatency{p, latency}
+	b.insertLocked(b.newEntryLocked(p, score))
+}
 
-	//e==nil means this node has the highest latency so push it to last
-	if e == nil {
-		b.list.PushBack(elem)
-		return
+// newEntryLocked builds a bucketEntry for p with the next insertion
+// sequence number, stamping addedAt with the current time, and
+// score.AddedAt too unless the caller already set it. Callers must hold
+// b.lk.
+func (b *Bucket) newEntryLocked(p peer.ID, score PeerScore) *bucketEntry {
+	b.seq++
+	now := time.Now()
+	if score.AddedAt.IsZero() {
+		score.AddedAt = now
 	}
+	return &bucketEntry{id: p, score: score, seq: b.seq, addedAt: now}
+}
 
-	//e.Prev() == nil means this node is the first and has least latency so push it to front
-	if e.Prev() == nil {
-		b.list.PushFront(elem)
-		return
+// Peek returns the peer at the front of the bucket without removing it.
+func (b *Bucket) Peek() (peer.ID, bool) {
+	b.lk.RLock()
+	defer b.lk.RUnlock()
+	e := b.frontLocked()
+	if e == nil {
+		return "", false
 	}
+	return e.id, true
+}
 
-	//push the current peer just before the peer which has higher latency to it
-	b.list.InsertAfter(elem, e.Prev())
+// Range calls f for every peer in the bucket, front to back, until f
+// returns false or every peer has been visited.
+func (b *Bucket) Range(f func(peer.ID, time.Duration) bool) {
+	b.lk.RLock()
+	defer b.lk.RUnlock()
+	b.inOrderLocked(func(e *bucketEntry) bool {
+		return f(e.id, e.score.Latency)
+	})
+}
 
+// Worst returns up to n peers ranked worst by the bucket's comparator,
+// worst first, suitable for picking eviction candidates during churn.
+func (b *Bucket) Worst(n int) []peer.ID {
+	b.lk.RLock()
+	defer b.lk.RUnlock()
+	if n <= 0 {
+		return nil
+	}
+	out := make([]peer.ID, 0, n)
+	b.reverseOrderLocked(func(e *bucketEntry) bool {
+		out = append(out, e.id)
+		return len(out) < n
+	})
+	return out
 }
 
+// PopBack removes and returns the peer this bucket's comparator ranks
+// worst.
 func (b *Bucket) PopBack() peer.ID {
 	b.lk.Lock()
 	defer b.lk.Unlock()
-	last := b.list.Back()
-	b.list.Remove(last)
-	return last.Value.(PeerIDLatency).ID
+	e := b.backLocked()
+	if e == nil {
+		return ""
+	}
+	b.removeLocked(e.id)
+	return e.id
 }
 
 func (b *Bucket) Len() int {
 	b.lk.RLock()
 	defer b.lk.RUnlock()
-	return b.list.Len()
+	return len(b.entries)
 }
 
 // Split splits a buckets peers into two buckets, the methods receiver will have
@@ -127,21 +412,20 @@ func (b *Bucket) Split(cpl int, target ID) *Bucket {
 	b.lk.Lock()
 	defer b.lk.Unlock()
 
-	out := list.New()
 	newbuck := newBucket()
-	newbuck.list = out
-	e := b.list.Front()
-	for e != nil {
-		peerID := ConvertPeerID(e.Value.(PeerIDLatency).ID)
-		peerCPL := CommonPrefixLen(peerID, target)
-		if peerCPL > cpl {
-			cur := e
-			out.PushBack(e.Value)
-			e = e.Next()
-			b.list.Remove(cur)
-			continue
+	newbuck.cmp = b.cmp
+	newbuck.replacementCap = b.replacementCap
+	var moving []*bucketEntry
+	b.inOrderLocked(func(e *bucketEntry) bool {
+		peerID := ConvertPeerID(e.id)
+		if CommonPrefixLen(peerID, target) > cpl {
+			moving = append(moving, e)
 		}
-		e = e.Next()
+		return true
+	})
+	for _, e := range moving {
+		b.removeLocked(e.id)
+		newbuck.insertLocked(e)
 	}
 	return newbuck
 }
@@ -0,0 +1,106 @@
+//go:build oldbucketbench
+
+package kbucket
+
+// This file keeps the pre-treap, container/list-backed Bucket around solely
+// so BenchmarkBucket has something to run against when comparing the two
+// implementations; it isn't part of normal `go test ./...` runs. Build with
+// -tags=oldbucketbench to benchmark it alongside the current Bucket, e.g.:
+//
+//	go test -tags=oldbucketbench -bench=Bucket -benchmem .
+
+import (
+	"container/list"
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+type oldListEntry struct {
+	ID      peer.ID
+	Latency time.Duration
+}
+
+type oldListBucket struct {
+	list *list.List
+}
+
+func newOldListBucket() *oldListBucket {
+	return &oldListBucket{list: list.New()}
+}
+
+func (b *oldListBucket) Has(id peer.ID) bool {
+	for e := b.list.Front(); e != nil; e = e.Next() {
+		if e.Value.(oldListEntry).ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *oldListBucket) MoveToFront(id peer.ID) {
+	for e := b.list.Front(); e != nil; e = e.Next() {
+		if e.Value.(oldListEntry).ID == id {
+			b.list.MoveToFront(e)
+		}
+	}
+}
+
+func (b *oldListBucket) PushFrontWithLatency(p peer.ID, latency time.Duration) {
+	e := b.list.Front()
+	for ; e != nil; e = e.Next() {
+		if e.Value.(oldListEntry).Latency > latency {
+			break
+		}
+	}
+	elem := oldListEntry{p, latency}
+
+	if e == nil {
+		b.list.PushBack(elem)
+		return
+	}
+	if e.Prev() == nil {
+		b.list.PushFront(elem)
+		return
+	}
+	b.list.InsertAfter(elem, e.Prev())
+}
+
+func (b *oldListBucket) PopBack() peer.ID {
+	last := b.list.Back()
+	b.list.Remove(last)
+	return last.Value.(oldListEntry).ID
+}
+
+func benchOldListBucket(b *testing.B, size int) {
+	r := rand.New(rand.NewSource(1))
+	ids := make([]peer.ID, size)
+	for i := range ids {
+		ids[i] = randPeerID(r)
+	}
+
+	bucket := newOldListBucket()
+	for _, id := range ids {
+		bucket.PushFrontWithLatency(id, time.Duration(r.Intn(1000))*time.Millisecond)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		id := ids[i%len(ids)]
+		bucket.Has(id)
+		bucket.MoveToFront(id)
+		bucket.PushFrontWithLatency(randPeerID(r), time.Duration(r.Intn(1000))*time.Millisecond)
+		bucket.PopBack()
+	}
+}
+
+func BenchmarkOldListBucket(b *testing.B) {
+	for _, size := range []int{20, 64, 128, 256} {
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			benchOldListBucket(b, size)
+		})
+	}
+}
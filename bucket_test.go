@@ -0,0 +1,196 @@
+package kbucket
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+func randPeerID(r *rand.Rand) peer.ID {
+	buf := make([]byte, 16)
+	r.Read(buf)
+	return peer.ID(buf)
+}
+
+// assertTreapMatchesEntries checks that the bucket's ordering (whichever
+// of the slice or treap is currently active) and b.entries agree on both
+// size and membership, catching the kind of map/ordering divergence an
+// insertLocked leaving a stale node behind would cause.
+func assertTreapMatchesEntries(t *testing.T, b *Bucket) {
+	t.Helper()
+	seen := make(map[peer.ID]bool, len(b.entries))
+	b.inOrderLocked(func(e *bucketEntry) bool {
+		if seen[e.id] {
+			t.Fatalf("ordering contains %q more than once", e.id)
+		}
+		seen[e.id] = true
+		return true
+	})
+	if len(seen) != len(b.entries) {
+		t.Fatalf("ordering has %d distinct entries, b.entries has %d", len(seen), len(b.entries))
+	}
+	for id := range b.entries {
+		if !seen[id] {
+			t.Fatalf("b.entries has %q but the ordering doesn't", id)
+		}
+	}
+}
+
+// assertOrdered checks that Range visits peers in non-decreasing latency
+// order, which is the invariant PushFrontWithLatency must maintain for any
+// peer that hasn't been pinned via MoveToFront.
+func assertOrdered(t *testing.T, b *Bucket, pinned map[peer.ID]bool) {
+	t.Helper()
+	var last time.Duration
+	var sawUnpinned bool
+	b.Range(func(id peer.ID, lat time.Duration) bool {
+		if pinned[id] {
+			return true
+		}
+		if sawUnpinned && lat < last {
+			t.Fatalf("ordering invariant violated: %v came after a lower latency", lat)
+		}
+		last = lat
+		sawUnpinned = true
+		return true
+	})
+}
+
+func FuzzBucketOrdering(f *testing.F) {
+	f.Add(int64(1), 50)
+	f.Fuzz(func(t *testing.T, seed int64, ops int) {
+		if ops <= 0 || ops > 500 {
+			t.Skip()
+		}
+		r := rand.New(rand.NewSource(seed))
+		b := newBucket()
+		pinned := make(map[peer.ID]bool)
+		var ids []peer.ID
+
+		for i := 0; i < ops; i++ {
+			switch r.Intn(5) {
+			case 0:
+				id := randPeerID(r)
+				b.PushFrontWithLatency(id, time.Duration(r.Intn(1000))*time.Millisecond)
+				ids = append(ids, id)
+			case 4:
+				// Re-push an id already in the bucket, as a caller refreshing
+				// a peer's latency would; must not orphan the old treap node.
+				if len(ids) == 0 {
+					continue
+				}
+				id := ids[r.Intn(len(ids))]
+				b.PushFrontWithLatency(id, time.Duration(r.Intn(1000))*time.Millisecond)
+				delete(pinned, id)
+			case 1:
+				if len(ids) == 0 {
+					continue
+				}
+				id := ids[r.Intn(len(ids))]
+				b.Remove(id)
+				delete(pinned, id)
+			case 2:
+				if len(ids) == 0 {
+					continue
+				}
+				id := ids[r.Intn(len(ids))]
+				if b.Has(id) {
+					b.MoveToFront(id)
+					pinned[id] = true
+				}
+			case 3:
+				if b.Len() == 0 {
+					continue
+				}
+				evicted := b.PopBack()
+				delete(pinned, evicted)
+			}
+			if b.Len() != len(b.entries) {
+				t.Fatalf("Len() = %d, want %d", b.Len(), len(b.entries))
+			}
+			assertTreapMatchesEntries(t, b)
+			assertOrdered(t, b, pinned)
+		}
+	})
+}
+
+// TestPushFrontRefreshDoesNotOrphanTreapNode reproduces a regression where
+// re-pushing a peer already in the bucket (e.g. to refresh its latency)
+// left the old bucketEntry in the treap while b.entries only tracked the
+// new one, so Has/Remove and Peers/Range disagreed afterward.
+func TestPushFrontRefreshDoesNotOrphanTreapNode(t *testing.T) {
+	b := newBucket()
+	b.PushFrontWithLatency("p", 10*time.Millisecond)
+	b.PushFrontWithLatency("p", 20*time.Millisecond)
+	assertTreapMatchesEntries(t, b)
+
+	if !b.Remove("p") {
+		t.Fatalf("Remove should report the peer was present")
+	}
+	if b.Has("p") {
+		t.Fatalf("p should be gone after Remove")
+	}
+	if got := b.Peers(); len(got) != 0 {
+		t.Fatalf("Peers() should be empty after Remove, got %v", got)
+	}
+	if b.Len() != 0 {
+		t.Fatalf("Len() = %d, want 0", b.Len())
+	}
+}
+
+// TestBucketPromotesToTreapPastSmallBucketLimit checks that a Bucket
+// starts out on the small-size slice path, switches to the treap once it
+// grows past smallBucketLimit, and keeps the same front-to-back ordering
+// across that switch.
+func TestBucketPromotesToTreapPastSmallBucketLimit(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	b := newBucket()
+	for i := 0; i < smallBucketLimit; i++ {
+		b.PushFrontWithLatency(randPeerID(r), time.Duration(i)*time.Millisecond)
+	}
+	if b.useTreap {
+		t.Fatalf("bucket should still be on the slice path at the limit")
+	}
+	assertOrdered(t, b, nil)
+	assertTreapMatchesEntries(t, b)
+
+	b.PushFrontWithLatency(randPeerID(r), time.Duration(smallBucketLimit)*time.Millisecond)
+	if !b.useTreap {
+		t.Fatalf("bucket should have promoted to the treap past the limit")
+	}
+	assertOrdered(t, b, nil)
+	assertTreapMatchesEntries(t, b)
+}
+
+func benchBucket(b *testing.B, size int) {
+	r := rand.New(rand.NewSource(1))
+	ids := make([]peer.ID, size)
+	for i := range ids {
+		ids[i] = randPeerID(r)
+	}
+
+	bucket := newBucket()
+	for _, id := range ids {
+		bucket.PushFrontWithLatency(id, time.Duration(r.Intn(1000))*time.Millisecond)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		id := ids[i%len(ids)]
+		bucket.Has(id)
+		bucket.MoveToFront(id)
+		bucket.PushFrontWithLatency(randPeerID(r), time.Duration(r.Intn(1000))*time.Millisecond)
+		bucket.PopBack()
+	}
+}
+
+func BenchmarkBucket(b *testing.B) {
+	for _, size := range []int{20, 64, 128, 256} {
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			benchBucket(b, size)
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package kbucket
+
+import "time"
+
+// PeerScore captures the signals a comparator ranks a peer on within its
+// bucket.
+type PeerScore struct {
+	Trusted     bool
+	Connected   bool
+	Latency     time.Duration
+	SuccessRate float64
+	AddedAt     time.Time
+}
+
+// PeerLess reports whether a should rank ahead of (closer to the front of
+// its bucket than) b. Implementations should be a strict weak ordering.
+type PeerLess func(a, b PeerScore) bool
+
+// latencyQuantum is the granularity latency is bucketed into before
+// comparison, so that near-equal RTTs don't reshuffle peers on every ping.
+const latencyQuantum = 25 * time.Millisecond
+
+func quantizeLatency(d time.Duration) time.Duration {
+	return d / latencyQuantum
+}
+
+// MultilessComparator returns the default PeerLess: a chain of comparisons
+// where each criterion only breaks ties left by the ones before it -
+// trusted, then connected, then quantized latency, then success rate, then
+// insertion age (older first).
+func MultilessComparator() PeerLess {
+	return func(a, b PeerScore) bool {
+		if a.Trusted != b.Trusted {
+			return a.Trusted
+		}
+		if a.Connected != b.Connected {
+			return a.Connected
+		}
+		if qa, qb := quantizeLatency(a.Latency), quantizeLatency(b.Latency); qa != qb {
+			return qa < qb
+		}
+		if a.SuccessRate != b.SuccessRate {
+			return a.SuccessRate > b.SuccessRate
+		}
+		return a.AddedAt.Before(b.AddedAt)
+	}
+}
+
+// BucketOption configures a Bucket at construction time, via NewBucket.
+type BucketOption func(*bucketOptions)
+
+type bucketOptions struct {
+	cmp                  PeerLess
+	replacementCacheSize int
+}
+
+func newBucketOptions(opts ...BucketOption) *bucketOptions {
+	o := &bucketOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithPeerComparator overrides the comparator a Bucket uses to order its
+// peers. Buckets created without this option keep ranking peers by raw
+// latency, as PushFrontWithLatency always has, until the first
+// PushFrontScored call installs MultilessComparator; it is equivalent to
+// calling SetComparator right after NewBucket.
+//
+// TODO: this was requested as a RoutingTable option so a whole table could
+// be configured with one comparator, but no RoutingTable type exists in
+// this module yet - callers wire it per Bucket until that type lands.
+func WithPeerComparator(less PeerLess) BucketOption {
+	return func(o *bucketOptions) {
+		o.cmp = less
+	}
+}
+
+// WithReplacementCacheSize bounds how many candidates a bucket's
+// replacement cache holds while waiting to be promoted by
+// Bucket.TryEvictAndPromote. The default is defaultReplacementCacheSize;
+// it is equivalent to calling SetReplacementCacheSize right after
+// NewBucket.
+func WithReplacementCacheSize(n int) BucketOption {
+	return func(o *bucketOptions) {
+		o.replacementCacheSize = n
+	}
+}
@@ -0,0 +1,102 @@
+package kbucket
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+func TestMultilessComparatorPrecedence(t *testing.T) {
+	less := MultilessComparator()
+
+	trusted := PeerScore{Trusted: true}
+	untrusted := PeerScore{Trusted: false, Connected: true, Latency: time.Millisecond}
+	if !less(trusted, untrusted) {
+		t.Fatalf("trusted peer should outrank a connected, lower-latency one")
+	}
+
+	connected := PeerScore{Connected: true, Latency: 100 * time.Millisecond}
+	disconnected := PeerScore{Connected: false, Latency: time.Millisecond}
+	if !less(connected, disconnected) {
+		t.Fatalf("connected peer should outrank a disconnected, lower-latency one")
+	}
+
+	a := PeerScore{Connected: true, Latency: 10 * time.Millisecond}
+	b := PeerScore{Connected: true, Latency: 12 * time.Millisecond}
+	if less(a, b) || less(b, a) {
+		t.Fatalf("latencies within the same 25ms quantum should compare equal")
+	}
+
+	faster := PeerScore{Connected: true, Latency: 10 * time.Millisecond}
+	slower := PeerScore{Connected: true, Latency: 80 * time.Millisecond}
+	if !less(faster, slower) {
+		t.Fatalf("lower quantized latency should outrank higher")
+	}
+}
+
+func TestPushFrontScoredRebuildsExistingOrder(t *testing.T) {
+	b := newBucket()
+	b.PushFrontWithLatency("x", 12*time.Millisecond)
+	b.PushFrontWithLatency("y", 3*time.Millisecond)
+
+	// Before any comparator is set, x and y are ordered by raw latency:
+	// y (3ms) comes before x (12ms).
+	var before []peer.ID
+	b.Range(func(id peer.ID, _ time.Duration) bool {
+		before = append(before, id)
+		return true
+	})
+	if len(before) != 2 || before[0] != "y" || before[1] != "x" {
+		t.Fatalf("expected [y x] under raw latency ordering, got %v", before)
+	}
+
+	// Switching to the comparator (implicitly, via PushFrontScored) must
+	// re-rank x and y too, not just the newly scored peer: x and y fall
+	// in the same 25ms latency quantum, so MultilessComparator's AddedAt
+	// tiebreak puts x (added first) ahead of y.
+	b.PushFrontScored("z", PeerScore{Latency: time.Second})
+
+	var after []peer.ID
+	b.Range(func(id peer.ID, _ time.Duration) bool {
+		after = append(after, id)
+		return true
+	})
+	if len(after) != 3 || after[0] != "x" || after[1] != "y" {
+		t.Fatalf("expected x before y after the comparator switch, got %v", after)
+	}
+}
+
+func TestNewBucketAppliesOptions(t *testing.T) {
+	b := NewBucket(WithPeerComparator(MultilessComparator()), WithReplacementCacheSize(1))
+	b.PushFrontScored("slow", PeerScore{Latency: 500 * time.Millisecond})
+	b.PushFrontScored("trusted", PeerScore{Trusted: true, Latency: 900 * time.Millisecond})
+
+	front, ok := b.Peek()
+	if !ok || front != "trusted" {
+		t.Fatalf("expected WithPeerComparator to take effect, got front %q (ok=%v)", front, ok)
+	}
+
+	b.AddReplacement("r1")
+	b.AddReplacement("r2")
+	if got := len(b.replacements); got != 1 {
+		t.Fatalf("expected WithReplacementCacheSize(1) to cap the replacement cache, got %d entries", got)
+	}
+}
+
+func TestBucketPushFrontScoredUsesComparator(t *testing.T) {
+	b := newBucket()
+	b.PushFrontScored("slow", PeerScore{Latency: 500 * time.Millisecond})
+	b.PushFrontScored("trusted", PeerScore{Trusted: true, Latency: 900 * time.Millisecond})
+	b.PushFrontScored("fast", PeerScore{Latency: 10 * time.Millisecond})
+
+	front, ok := b.Peek()
+	if !ok || front != "trusted" {
+		t.Fatalf("expected trusted peer at front, got %q (ok=%v)", front, ok)
+	}
+
+	worst := b.Worst(1)
+	if len(worst) != 1 || worst[0] != "slow" {
+		t.Fatalf("expected slow peer as worst, got %v", worst)
+	}
+}
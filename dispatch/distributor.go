@@ -0,0 +1,308 @@
+// Package dispatch implements a latency- and load-aware request
+// distributor on top of a kbucket routing table's ordering: peers are
+// offered CPL-nearest bucket first, and within a bucket in the latency
+// order Bucket already maintains, so go-libp2p-kad-dht (or any other
+// caller) can replace an ad-hoc peer-selection loop with a single fair
+// scheduler.
+package dispatch
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	kbucket "github.com/adiedjx/go-libp2p-kbucket"
+)
+
+// Request describes a fan-out the Distributor should supply candidate
+// peers for.
+type Request struct {
+	Target   kbucket.ID
+	MaxPeers int
+	Deadline time.Time
+	Cost     func(peer.ID) int
+}
+
+// BucketSource supplies a routing table's buckets ordered nearest (highest
+// CPL against Request.Target) to farthest. *kbucket.RoutingTable is the
+// intended implementation, but it isn't part of this module; Buckets lets
+// a caller adapt a plain slice in the meantime.
+type BucketSource interface {
+	OrderedBuckets(target kbucket.ID) []*kbucket.Bucket
+}
+
+// Buckets adapts a slice that is already ordered nearest-first into a
+// BucketSource, ignoring the target (useful for tests and for callers
+// that only have a single flat bucket list).
+type Buckets []*kbucket.Bucket
+
+func (bs Buckets) OrderedBuckets(kbucket.ID) []*kbucket.Bucket { return bs }
+
+// Option configures a Distributor at construction time.
+type Option func(*Distributor)
+
+// WithRate sets the per-peer token-bucket refill rate (tokens/second) and
+// burst size. The default is 5 tokens/second with a burst of 5.
+func WithRate(perSecond, burst float64) Option {
+	return func(d *Distributor) {
+		d.rate = perSecond
+		d.burst = burst
+	}
+}
+
+// WithBackoff sets the base and max durations a peer is excluded for after
+// consecutive Report failures. The default is 500ms doubling up to 1m.
+func WithBackoff(base, maxBackoff time.Duration) Option {
+	return func(d *Distributor) {
+		d.baseBackoff = base
+		d.maxBackoff = maxBackoff
+	}
+}
+
+// WithMaxInflight caps how many requests may be outstanding to a single
+// peer at once; admit refuses further peers once this is reached. The
+// default is 4. A value <= 0 disables the cap.
+func WithMaxInflight(n int) Option {
+	return func(d *Distributor) {
+		d.maxInflight = n
+	}
+}
+
+// inflightPenalty is how much latency-equivalent weight one outstanding
+// request to a peer adds when ranking same-bucket candidates, so a lightly
+// loaded farther-latency peer can be preferred over a heavily loaded
+// nearer one.
+const inflightPenalty = 20 * time.Millisecond
+
+// Distributor schedules peers to try for a Request, drawn CPL-nearest
+// bucket first and latency-ordered within each bucket, while tracking
+// per-peer inflight load, a token-bucket rate limit, and exponential
+// backoff on reported failures.
+type Distributor struct {
+	source BucketSource
+
+	rate        float64
+	burst       float64
+	baseBackoff time.Duration
+	maxBackoff  time.Duration
+	maxInflight int
+
+	mu    sync.Mutex
+	peers map[peer.ID]*peerState
+}
+
+type peerState struct {
+	inflight     int
+	tokens       float64
+	lastRefill   time.Time
+	backoff      time.Duration
+	blockedUntil time.Time
+}
+
+// New creates a Distributor drawing peers from source.
+func New(source BucketSource, opts ...Option) *Distributor {
+	d := &Distributor{
+		source:      source,
+		rate:        5,
+		burst:       5,
+		baseBackoff: 500 * time.Millisecond,
+		maxBackoff:  time.Minute,
+		maxInflight: 4,
+		peers:       make(map[peer.ID]*peerState),
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+// candidate is a peer awaiting dispatch within a single bucket, along
+// with the signals used to rank it against its bucket-mates.
+type candidate struct {
+	id      peer.ID
+	latency time.Duration
+}
+
+// Submit schedules up to req.MaxPeers candidates onto the returned
+// channel, nearest bucket first and, within a bucket, by latency
+// re-ordered for current in-flight load (inflightPenalty per outstanding
+// request), skipping peers still rate-limited, backed off, or at their
+// in-flight cap. The channel is closed once MaxPeers peers have been
+// sent, the buckets are exhausted, ctx is done, or req.Deadline passes.
+func (d *Distributor) Submit(ctx context.Context, req Request) <-chan peer.ID {
+	out := make(chan peer.ID)
+	go func() {
+		defer close(out)
+
+		if !req.Deadline.IsZero() {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithDeadline(ctx, req.Deadline)
+			defer cancel()
+		}
+
+		sent := 0
+		for _, bucket := range d.source.OrderedBuckets(req.Target) {
+			if req.MaxPeers > 0 && sent >= req.MaxPeers {
+				return
+			}
+			var candidates []candidate
+			bucket.Range(func(id peer.ID, latency time.Duration) bool {
+				candidates = append(candidates, candidate{id: id, latency: latency})
+				return true
+			})
+			d.rankByLoad(candidates)
+
+			for _, c := range candidates {
+				if req.MaxPeers > 0 && sent >= req.MaxPeers {
+					return
+				}
+				cost := 1
+				if req.Cost != nil {
+					cost = req.Cost(c.id)
+				}
+				if !d.admit(c.id, cost) {
+					continue
+				}
+				select {
+				case out <- c.id:
+					sent++
+				case <-ctx.Done():
+					// c.id was admitted but never handed out: release the
+					// inflight slot admit took for it.
+					d.mu.Lock()
+					if st, ok := d.peers[c.id]; ok && st.inflight > 0 {
+						st.inflight--
+					}
+					d.mu.Unlock()
+					return
+				}
+			}
+		}
+	}()
+	return out
+}
+
+// rankByLoad re-sorts candidates, drawn from a single bucket in latency
+// order, by latency plus inflightPenalty for each outstanding request -
+// so a peer with equal or slightly higher latency but less current load
+// is tried first.
+func (d *Distributor) rankByLoad(candidates []candidate) {
+	d.mu.Lock()
+	load := make(map[peer.ID]int, len(candidates))
+	for _, c := range candidates {
+		load[c.id] = d.inflightLocked(c.id)
+	}
+	d.mu.Unlock()
+
+	weight := func(c candidate) time.Duration {
+		return c.latency + time.Duration(load[c.id])*inflightPenalty
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return weight(candidates[i]) < weight(candidates[j])
+	})
+}
+
+// admit reports whether id may be dispatched now, consuming one token (or
+// cost tokens) from its bucket and marking it inflight if so. It returns
+// false if id is still backed off, lacks tokens, or is already at
+// maxInflight.
+func (d *Distributor) admit(id peer.ID, cost int) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	st := d.peerStateLocked(id)
+	now := timeNow()
+	if now.Before(st.blockedUntil) {
+		return false
+	}
+	if d.maxInflight > 0 && st.inflight >= d.maxInflight {
+		return false
+	}
+	d.refillLocked(st, now)
+
+	need := float64(cost)
+	if need <= 0 {
+		need = 1
+	}
+	if st.tokens < need {
+		return false
+	}
+	st.tokens -= need
+	st.inflight++
+	return true
+}
+
+// inflightLocked reports id's current inflight count without creating
+// state for peers that have never been dispatched, unlike
+// peerStateLocked. Callers must hold d.mu.
+func (d *Distributor) inflightLocked(id peer.ID) int {
+	if st, ok := d.peers[id]; ok {
+		return st.inflight
+	}
+	return 0
+}
+
+// Report records the outcome of a peer dispatched by Submit, releasing
+// its inflight slot and, on failure, backing it off exponentially; a
+// success resets the backoff.
+func (d *Distributor) Report(id peer.ID, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	st := d.peerStateLocked(id)
+	if st.inflight > 0 {
+		st.inflight--
+	}
+	if err == nil {
+		st.backoff = 0
+		st.blockedUntil = time.Time{}
+		return
+	}
+
+	if st.backoff == 0 {
+		st.backoff = d.baseBackoff
+	} else {
+		st.backoff *= 2
+		if st.backoff > d.maxBackoff {
+			st.backoff = d.maxBackoff
+		}
+	}
+	st.blockedUntil = timeNow().Add(st.backoff)
+}
+
+// Inflight reports how many requests to id are currently outstanding.
+func (d *Distributor) Inflight(id peer.ID) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if st, ok := d.peers[id]; ok {
+		return st.inflight
+	}
+	return 0
+}
+
+func (d *Distributor) peerStateLocked(id peer.ID) *peerState {
+	st, ok := d.peers[id]
+	if !ok {
+		st = &peerState{tokens: d.burst, lastRefill: timeNow()}
+		d.peers[id] = st
+	}
+	return st
+}
+
+func (d *Distributor) refillLocked(st *peerState, now time.Time) {
+	elapsed := now.Sub(st.lastRefill).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+	st.tokens += elapsed * d.rate
+	if st.tokens > d.burst {
+		st.tokens = d.burst
+	}
+	st.lastRefill = now
+}
+
+// timeNow is a var so tests can fake the clock.
+var timeNow = time.Now
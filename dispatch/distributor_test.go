@@ -0,0 +1,86 @@
+package dispatch
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	kbucket "github.com/adiedjx/go-libp2p-kbucket"
+)
+
+func TestSubmitDrawsNearestBucketFirst(t *testing.T) {
+	near := kbucket.NewBucket()
+	near.PushFrontWithLatency("near-a", 10*time.Millisecond)
+	far := kbucket.NewBucket()
+	far.PushFrontWithLatency("far-a", 5*time.Millisecond)
+
+	d := New(Buckets{near, far})
+	out := d.Submit(context.Background(), Request{MaxPeers: 2})
+
+	var got []peer.ID
+	for id := range out {
+		got = append(got, id)
+	}
+	if len(got) != 2 || got[0] != "near-a" || got[1] != "far-a" {
+		t.Fatalf("expected [near-a far-a], got %v", got)
+	}
+}
+
+func TestReportBackoffExcludesPeerUntilElapsed(t *testing.T) {
+	b := kbucket.NewBucket()
+	b.PushFront("p1")
+
+	d := New(Buckets{b}, WithBackoff(time.Minute, time.Minute))
+	now := time.Now()
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	out := d.Submit(context.Background(), Request{MaxPeers: 1})
+	first := <-out
+	if first != "p1" {
+		t.Fatalf("expected p1, got %q", first)
+	}
+	d.Report("p1", errors.New("timeout"))
+
+	out2 := d.Submit(context.Background(), Request{MaxPeers: 1})
+	if _, ok := <-out2; ok {
+		t.Fatalf("peer should still be backed off right after a failure")
+	}
+
+	now = now.Add(2 * time.Minute)
+	out3 := d.Submit(context.Background(), Request{MaxPeers: 1})
+	if id, ok := <-out3; !ok || id != "p1" {
+		t.Fatalf("peer should be admitted again once backoff elapses")
+	}
+}
+
+func TestRateLimitExcludesPeerUntilRefill(t *testing.T) {
+	b := kbucket.NewBucket()
+	b.PushFront("p1")
+
+	d := New(Buckets{b}, WithRate(1, 1))
+	now := time.Now()
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	out := d.Submit(context.Background(), Request{MaxPeers: 1})
+	first := <-out
+	if first != "p1" {
+		t.Fatalf("expected p1, got %q", first)
+	}
+	d.Report("p1", nil)
+
+	out2 := d.Submit(context.Background(), Request{MaxPeers: 1})
+	if _, ok := <-out2; ok {
+		t.Fatalf("peer should still be rate-limited right after exhausting its burst")
+	}
+
+	now = now.Add(time.Second)
+	out3 := d.Submit(context.Background(), Request{MaxPeers: 1})
+	if id, ok := <-out3; !ok || id != "p1" {
+		t.Fatalf("peer should be admitted again once tokens refill")
+	}
+}
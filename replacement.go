@@ -0,0 +1,114 @@
+package kbucket
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// defaultReplacementCacheSize is how many replacement candidates a Bucket
+// holds when none is configured via WithReplacementCacheSize.
+const defaultReplacementCacheSize = 8
+
+// AddReplacement offers p as a candidate to take over the worst-ranked
+// peer's slot the next time TryEvictAndPromote finds that peer
+// unresponsive. The cache is bounded; once full, the oldest candidate is
+// dropped in favor of the newest.
+func (b *Bucket) AddReplacement(p peer.ID) {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+	if _, ok := b.entries[p]; ok {
+		return
+	}
+	for _, e := range b.replacements {
+		if e.id == p {
+			return
+		}
+	}
+	b.seq++
+	b.replacements = append(b.replacements, &bucketEntry{id: p, seq: b.seq})
+	limit := b.replacementCap
+	if limit <= 0 {
+		limit = defaultReplacementCacheSize
+	}
+	if over := len(b.replacements) - limit; over > 0 {
+		b.replacements = b.replacements[over:]
+	}
+}
+
+// SetReplacementCacheSize bounds the number of candidates AddReplacement
+// will retain.
+func (b *Bucket) SetReplacementCacheSize(n int) {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+	b.replacementCap = n
+}
+
+// TryEvictAndPromote probes the bucket's current worst-ranked peer and,
+// if the probe fails, evicts it in favor of the freshest replacement
+// candidate; otherwise it treats a successful probe as a liveness signal
+// and moves that peer to the front. It reports ok=false if the bucket is
+// empty, ctx is already done, the probe succeeds, or there is no
+// replacement to promote.
+//
+// TODO: the intended caller is RoutingTable.TryAddPeer on overflow, so
+// peers bumped from a full bucket aren't simply dropped, but no
+// RoutingTable type exists in this module yet - this method is a
+// Bucket-only building block until that table-level type and its overflow
+// path land.
+func (b *Bucket) TryEvictAndPromote(ctx context.Context, probe func(peer.ID) error) (evicted, promoted peer.ID, ok bool) {
+	if err := ctx.Err(); err != nil {
+		return "", "", false
+	}
+
+	b.lk.RLock()
+	tail := b.backLocked()
+	if tail == nil {
+		b.lk.RUnlock()
+		return "", "", false
+	}
+	worst := tail.id
+	b.lk.RUnlock()
+
+	if err := probe(worst); err == nil {
+		b.MoveToFront(worst)
+		return "", "", false
+	}
+
+	b.lk.Lock()
+	defer b.lk.Unlock()
+	atomic.AddUint64(&b.failedProbes, 1)
+
+	if len(b.replacements) == 0 {
+		return "", "", false
+	}
+
+	// worst was read under a since-released RLock; a concurrent caller may
+	// have already evicted it (or it may no longer be the tail), so only
+	// commit the promotion if removing it actually frees a slot.
+	if b.removeLocked(worst) == nil {
+		return "", "", false
+	}
+
+	repl := b.replacements[len(b.replacements)-1]
+	b.replacements = b.replacements[:len(b.replacements)-1]
+
+	b.seq++
+	repl.seq = b.seq
+	b.insertLocked(repl)
+	atomic.AddUint64(&b.promotions, 1)
+	return worst, repl.id, true
+}
+
+// Promotions reports how many times TryEvictAndPromote has promoted a
+// replacement candidate into this bucket.
+func (b *Bucket) Promotions() uint64 {
+	return atomic.LoadUint64(&b.promotions)
+}
+
+// FailedProbes reports how many times TryEvictAndPromote's liveness probe
+// has failed for this bucket's worst-ranked peer.
+func (b *Bucket) FailedProbes() uint64 {
+	return atomic.LoadUint64(&b.failedProbes)
+}
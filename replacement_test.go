@@ -0,0 +1,118 @@
+package kbucket
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+func TestTryEvictAndPromoteOnFailedProbe(t *testing.T) {
+	b := newBucket()
+	b.PushFrontWithLatency("alive", 10*time.Millisecond)
+	b.PushFrontWithLatency("worst", 900*time.Millisecond)
+	b.AddReplacement("candidate")
+
+	evicted, promoted, ok := b.TryEvictAndPromote(context.Background(), func(p peer.ID) error {
+		return errors.New("unreachable")
+	})
+	if !ok || evicted != "worst" || promoted != "candidate" {
+		t.Fatalf("got evicted=%q promoted=%q ok=%v", evicted, promoted, ok)
+	}
+	if b.Has("worst") {
+		t.Fatalf("worst peer should have been evicted")
+	}
+	if !b.Has("candidate") {
+		t.Fatalf("candidate should have been promoted into the bucket")
+	}
+	if b.Promotions() != 1 || b.FailedProbes() != 1 {
+		t.Fatalf("expected 1 promotion and 1 failed probe, got %d/%d", b.Promotions(), b.FailedProbes())
+	}
+}
+
+func TestTryEvictAndPromoteOnSuccessfulProbe(t *testing.T) {
+	b := newBucket()
+	b.PushFrontWithLatency("alive", 10*time.Millisecond)
+	b.PushFrontWithLatency("worst", 900*time.Millisecond)
+	b.AddReplacement("candidate")
+
+	_, _, ok := b.TryEvictAndPromote(context.Background(), func(p peer.ID) error {
+		return nil
+	})
+	if ok {
+		t.Fatalf("a successful probe should not report an eviction")
+	}
+	if !b.Has("worst") {
+		t.Fatalf("worst peer should stay in the bucket after a successful probe")
+	}
+	front, _ := b.Peek()
+	if front != "worst" {
+		t.Fatalf("a live worst peer should be moved to front, got %q", front)
+	}
+}
+
+// TestTryEvictAndPromoteConcurrentCallersDontOvergrow reproduces a race
+// where two callers both read the same stale tail peer before either
+// eviction lands: only one removeLocked of that peer should actually free
+// a slot, so each promotion replaces exactly one evicted peer and the
+// bucket must stay at its original size however the two calls interleave.
+func TestTryEvictAndPromoteConcurrentCallersDontOvergrow(t *testing.T) {
+	b := newBucket()
+	b.PushFrontWithLatency("alive", 10*time.Millisecond)
+	b.PushFrontWithLatency("worst", 900*time.Millisecond)
+	b.AddReplacement("r1")
+	b.AddReplacement("r2")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.TryEvictAndPromote(context.Background(), func(p peer.ID) error {
+				return errors.New("unreachable")
+			})
+		}()
+	}
+	wg.Wait()
+
+	if got := b.Len(); got != 2 {
+		t.Fatalf("bucket should stay at its original size of 2, got %d: %v", got, b.Peers())
+	}
+	if b.Has("worst") {
+		t.Fatalf("worst peer should have been evicted exactly once")
+	}
+}
+
+func TestAddReplacementEvictsOldestOverCap(t *testing.T) {
+	b := newBucket()
+	for i := 0; i < defaultReplacementCacheSize+1; i++ {
+		b.AddReplacement(peer.ID(rune('a' + i)))
+	}
+	if got := len(b.replacements); got != defaultReplacementCacheSize {
+		t.Fatalf("expected cache bounded to %d entries, got %d", defaultReplacementCacheSize, got)
+	}
+	if b.replacements[0].id == "a" {
+		t.Fatalf("expected oldest candidate to be evicted, found it still present")
+	}
+	if last := b.replacements[len(b.replacements)-1].id; last != peer.ID(rune('a'+defaultReplacementCacheSize)) {
+		t.Fatalf("expected newest candidate to survive, got %q", last)
+	}
+}
+
+func TestAddReplacementHonorsCustomCacheSize(t *testing.T) {
+	b := newBucket()
+	b.SetReplacementCacheSize(2)
+	b.AddReplacement("r1")
+	b.AddReplacement("r2")
+	b.AddReplacement("r3")
+
+	if got := len(b.replacements); got != 2 {
+		t.Fatalf("expected custom cap of 2 to be honored, got %d entries", got)
+	}
+	if b.replacements[0].id != "r2" || b.replacements[1].id != "r3" {
+		t.Fatalf("expected [r2 r3] to remain, got %v", []peer.ID{b.replacements[0].id, b.replacements[1].id})
+	}
+}
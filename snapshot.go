@@ -0,0 +1,114 @@
+package kbucket
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// snapshotVersion1 is the only wire format version Bucket snapshots
+// currently support; it is written as the first byte of Snapshot's
+// output so future formats can be detected and rejected cleanly.
+const snapshotVersion1 = 1
+
+// snapshotEntry is the on-disk representation of a single bucketEntry.
+// Fields are exported so encoding/gob can see them. ID is stored as raw
+// bytes rather than peer.ID: gob can't round-trip a bare peer.ID (it
+// decodes with a "length greater than remaining number of bytes" error),
+// so the conversion happens at the toSnapshot/restore boundary instead.
+type snapshotEntry struct {
+	ID      []byte
+	Score   PeerScore
+	Pinned  bool
+	PinSeq  uint64
+	AddedAt time.Time
+}
+
+func (e *bucketEntry) toSnapshot() snapshotEntry {
+	return snapshotEntry{ID: []byte(e.id), Score: e.score, Pinned: e.pinned, PinSeq: e.pinSeq, AddedAt: e.addedAt}
+}
+
+// Snapshot serializes the bucket's peers, their scores and insertion
+// timestamps, front to back, into a versioned byte string suitable for
+// LoadSnapshot. It does not include the comparator or replacement cache.
+func (b *Bucket) Snapshot() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := b.WriteSnapshot(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// WriteSnapshot is the streaming form of Snapshot, for large tables.
+func (b *Bucket) WriteSnapshot(w io.Writer) error {
+	b.lk.RLock()
+	defer b.lk.RUnlock()
+
+	if _, err := w.Write([]byte{snapshotVersion1}); err != nil {
+		return err
+	}
+	var entries []snapshotEntry
+	b.inOrderLocked(func(e *bucketEntry) bool {
+		entries = append(entries, e.toSnapshot())
+		return true
+	})
+	return gob.NewEncoder(w).Encode(entries)
+}
+
+// LoadSnapshot restores a Bucket previously produced by Snapshot or
+// WriteSnapshot. Every restored peer is checked against localID: entries
+// whose common prefix length with localID isn't exactly cpl are rejected,
+// since they don't belong in this bucket. If maxAge is positive, entries
+// older than maxAge are also dropped. Peer order (including any pinned
+// front position) is preserved for a bucket on the default latency
+// ordering; the restored bucket has no comparator set, so a bucket that
+// was ordered by one comes back ordered by latency.
+//
+// TODO: this was requested as RoutingTable.Snapshot/LoadSnapshot,
+// reconstructing every bucket of a table by recomputing CPL against the
+// local ID and routing each entry to its bucket automatically. No
+// RoutingTable type exists in this module yet, so this only round-trips
+// one Bucket at a caller-supplied cpl; the caller has to already know
+// that bucket's CPL boundary to use it.
+func LoadSnapshot(data []byte, localID ID, cpl int, maxAge time.Duration) (*Bucket, error) {
+	return ReadSnapshot(bytes.NewReader(data), localID, cpl, maxAge)
+}
+
+// ReadSnapshot is the streaming form of LoadSnapshot, for large tables.
+func ReadSnapshot(r io.Reader, localID ID, cpl int, maxAge time.Duration) (*Bucket, error) {
+	var version [1]byte
+	if _, err := io.ReadFull(r, version[:]); err != nil {
+		return nil, fmt.Errorf("kbucket: reading snapshot version: %w", err)
+	}
+	if version[0] != snapshotVersion1 {
+		return nil, fmt.Errorf("kbucket: unsupported snapshot version %d", version[0])
+	}
+
+	var entries []snapshotEntry
+	if err := gob.NewDecoder(r).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("kbucket: decoding snapshot: %w", err)
+	}
+
+	b := newBucket()
+	now := time.Now()
+	for _, se := range entries {
+		id := peer.ID(se.ID)
+		if CommonPrefixLen(ConvertPeerID(id), localID) != cpl {
+			continue
+		}
+		if maxAge > 0 && now.Sub(se.AddedAt) > maxAge {
+			continue
+		}
+		if se.PinSeq > b.seq {
+			b.seq = se.PinSeq
+		}
+		b.seq++
+		e := &bucketEntry{id: id, score: se.Score, pinned: se.Pinned, pinSeq: se.PinSeq, seq: b.seq, addedAt: se.AddedAt}
+		b.insertLocked(e)
+	}
+	return b, nil
+}
@@ -0,0 +1,56 @@
+package kbucket
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSnapshotRoundTripKeepsMatchingBucket(t *testing.T) {
+	b := newBucket()
+	b.PushFrontWithLatency("fast", 10*time.Millisecond)
+	b.PushFrontWithLatency("slow", 400*time.Millisecond)
+	b.MoveToFront("slow")
+
+	data, err := b.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+
+	// "slow" trivially shares its own full CPL with itself; two distinct
+	// peer IDs essentially never do, so restoring against that CPL keeps
+	// "slow" and rejects "fast" as belonging to a different bucket.
+	localID := ConvertPeerID("slow")
+	cpl := CommonPrefixLen(ConvertPeerID("slow"), localID)
+
+	restored, err := LoadSnapshot(data, localID, cpl, 0)
+	if err != nil {
+		t.Fatalf("LoadSnapshot: %v", err)
+	}
+	if got := restored.Peers(); len(got) != 1 || got[0] != "slow" {
+		t.Fatalf("expected only [slow] restored, got %v", got)
+	}
+}
+
+func TestLoadSnapshotDropsStaleEntries(t *testing.T) {
+	b := newBucket()
+	b.PushFront("old")
+	b.lk.Lock()
+	b.entries["old"].addedAt = time.Now().Add(-time.Hour)
+	b.lk.Unlock()
+
+	data, err := b.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+
+	localID := ConvertPeerID("old")
+	cpl := CommonPrefixLen(ConvertPeerID("old"), localID)
+
+	restored, err := LoadSnapshot(data, localID, cpl, time.Minute)
+	if err != nil {
+		t.Fatalf("LoadSnapshot: %v", err)
+	}
+	if restored.Len() != 0 {
+		t.Fatalf("expected stale entry to be dropped, got %d peers", restored.Len())
+	}
+}
@@ -0,0 +1,133 @@
+package kbucket
+
+// treapNode is a node in the randomized balanced search tree backing a
+// Bucket's ordering. Keys are compared with the less function supplied to
+// each operation; priorities are assigned randomly at insertion time so the
+// tree stays balanced in expectation (O(log n) insert/delete/search)
+// regardless of insertion order.
+type treapNode struct {
+	entry    *bucketEntry
+	priority int64
+	left     *treapNode
+	right    *treapNode
+}
+
+type entryLess func(a, b *bucketEntry) bool
+
+// treapInsert inserts n into t, rotating as needed to preserve the heap
+// property on priority.
+func treapInsert(t, n *treapNode, less entryLess) *treapNode {
+	if t == nil {
+		return n
+	}
+	if less(n.entry, t.entry) {
+		t.left = treapInsert(t.left, n, less)
+		if t.left.priority > t.priority {
+			t = rotateRight(t)
+		}
+	} else {
+		t.right = treapInsert(t.right, n, less)
+		if t.right.priority > t.priority {
+			t = rotateLeft(t)
+		}
+	}
+	return t
+}
+
+// treapDelete removes the node holding e from t, if present.
+func treapDelete(t *treapNode, e *bucketEntry, less entryLess) *treapNode {
+	if t == nil {
+		return nil
+	}
+	switch {
+	case less(e, t.entry):
+		t.left = treapDelete(t.left, e, less)
+	case less(t.entry, e):
+		t.right = treapDelete(t.right, e, less)
+	default:
+		return treapMerge(t.left, t.right)
+	}
+	return t
+}
+
+// treapMerge combines two treaps known to be key-disjoint ranges (every key
+// in l is less than every key in r) into one, preserving the heap property.
+func treapMerge(l, r *treapNode) *treapNode {
+	if l == nil {
+		return r
+	}
+	if r == nil {
+		return l
+	}
+	if l.priority > r.priority {
+		l.right = treapMerge(l.right, r)
+		return l
+	}
+	r.left = treapMerge(l, r.left)
+	return r
+}
+
+func rotateRight(t *treapNode) *treapNode {
+	l := t.left
+	t.left = l.right
+	l.right = t
+	return l
+}
+
+func rotateLeft(t *treapNode) *treapNode {
+	r := t.right
+	t.right = r.left
+	r.left = t
+	return r
+}
+
+// treapFront returns the minimum-keyed node, i.e. the front of the bucket.
+func treapFront(t *treapNode) *treapNode {
+	if t == nil {
+		return nil
+	}
+	for t.left != nil {
+		t = t.left
+	}
+	return t
+}
+
+// treapBack returns the maximum-keyed node, i.e. the back of the bucket.
+func treapBack(t *treapNode) *treapNode {
+	if t == nil {
+		return nil
+	}
+	for t.right != nil {
+		t = t.right
+	}
+	return t
+}
+
+// treapInOrder walks t in ascending key order (front to back), calling f
+// for each entry until f returns false or the tree is exhausted.
+func treapInOrder(t *treapNode, f func(*bucketEntry) bool) bool {
+	if t == nil {
+		return true
+	}
+	if !treapInOrder(t.left, f) {
+		return false
+	}
+	if !f(t.entry) {
+		return false
+	}
+	return treapInOrder(t.right, f)
+}
+
+// treapReverseOrder walks t in descending key order (back to front).
+func treapReverseOrder(t *treapNode, f func(*bucketEntry) bool) bool {
+	if t == nil {
+		return true
+	}
+	if !treapReverseOrder(t.right, f) {
+		return false
+	}
+	if !f(t.entry) {
+		return false
+	}
+	return treapReverseOrder(t.left, f)
+}